@@ -0,0 +1,400 @@
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/terraform/command/format/jsonformat"
+	"github.com/hashicorp/terraform/configs/configschema"
+	"github.com/hashicorp/terraform/plans"
+	"github.com/mitchellh/colorstring"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// ResourceChange returns a string representation of a change to a particular
+// resource, for inclusion in user-facing plan output.
+//
+// The resource schema must be provided along with the change so that the
+// formatted output can include a representation of changes to the
+// resource's arguments.
+//
+// ResourceChange is implemented as a thin wrapper around jsonformat; see
+// that package's doc comment for why the diff is built there rather than
+// here.
+func ResourceChange(change *plans.ResourceInstanceChangeSrc, schema *configschema.Block, color *colorstring.Colorize) string {
+	return ResourceChangeFiltered(change, schema, color, nil)
+}
+
+// ResourceChangeFiltered is ResourceChange restricted to the subtrees of the
+// diff that fall under one of paths, plus whatever surrounding context (the
+// resource header, and any parent blocks between the root and a matching
+// path) is needed to make that restriction intelligible on its own. A nil or
+// empty paths shows the whole diff, which is what ResourceChange does.
+//
+// A Change is considered part of a requested subtree if its own path has one
+// of paths as a prefix, or if it's an ancestor of one of paths (so that the
+// chain of parent blocks down to the match is still rendered).
+func ResourceChangeFiltered(change *plans.ResourceInstanceChangeSrc, schema *configschema.Block, color *colorstring.Colorize, paths []cty.Path) string {
+	diff, err := jsonformat.BuildResourceChange(change, schema)
+	if err != nil {
+		// BuildResourceChange only fails if the stored change values don't
+		// conform to the given schema, which should never happen in
+		// practice: the schema is the one the change was created against.
+		panic(fmt.Sprintf("failed to render resource change for %s: %s", change.Addr, err))
+	}
+	diff.Change = filterChange(diff.Change, paths)
+	return renderResourceChange(diff, color)
+}
+
+// filterChange returns the subset of c (and, recursively, its Attributes and
+// Elements) that's relevant to paths, or nil if none of it is. A nil or
+// empty paths matches everything, so filterChange is a no-op in that case.
+func filterChange(c *jsonformat.Change, paths []cty.Path) *jsonformat.Change {
+	if len(paths) == 0 {
+		return c
+	}
+	if !pathRelevant(c.Path, paths) {
+		return nil
+	}
+	if c.Attributes == nil && c.Elements == nil {
+		return c
+	}
+
+	filtered := *c
+	if c.Attributes != nil {
+		attrs := make([]*jsonformat.Change, 0, len(c.Attributes))
+		for _, a := range c.Attributes {
+			if f := filterChange(a, paths); f != nil {
+				attrs = append(attrs, f)
+			}
+		}
+		filtered.Attributes = attrs
+	}
+	if c.Elements != nil {
+		elements := make([]*jsonformat.Change, 0, len(c.Elements))
+		for _, e := range c.Elements {
+			if f := filterChange(e, paths); f != nil {
+				elements = append(elements, f)
+			}
+		}
+		filtered.Elements = elements
+	}
+	return &filtered
+}
+
+// pathRelevant reports whether path should be kept when filtering to paths:
+// either path leads into one of them, or one of them leads into path.
+func pathRelevant(path cty.Path, paths []cty.Path) bool {
+	for _, p := range paths {
+		if pathHasPrefix(path, p) || pathHasPrefix(p, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// pathHasPrefix reports whether prefix's steps are a prefix of path's.
+func pathHasPrefix(path, prefix cty.Path) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i, step := range prefix {
+		if !pathStepsEqual(path[i], step) {
+			return false
+		}
+	}
+	return true
+}
+
+// pathStepsEqual compares two cty.PathStep values for equality. cty.Path
+// doesn't define this itself, since in general a path step's Key may not be
+// comparable with ==; we only need to handle the two step kinds this
+// package's paths are ever built from.
+func pathStepsEqual(a, b cty.PathStep) bool {
+	switch a := a.(type) {
+	case cty.GetAttrStep:
+		b, ok := b.(cty.GetAttrStep)
+		return ok && a.Name == b.Name
+	case cty.IndexStep:
+		b, ok := b.(cty.IndexStep)
+		return ok && a.Key.RawEquals(b.Key)
+	default:
+		return false
+	}
+}
+
+func renderResourceChange(diff *jsonformat.ResourceChange, color *colorstring.Colorize) string {
+	var buf bytes.Buffer
+
+	switch diff.Action {
+	case jsonformat.ActionCreate:
+		buf.WriteString(color.Color(fmt.Sprintf("  [bold]#[reset] %s [bold]will be created[reset]\n", diff.Address)))
+	case jsonformat.ActionDelete:
+		buf.WriteString(color.Color(fmt.Sprintf("  [bold]#[reset] %s [bold]will be destroyed[reset]\n", diff.Address)))
+	case jsonformat.ActionReplace:
+		buf.WriteString(color.Color(fmt.Sprintf("  [bold]#[reset] %s [bold]must be replaced[reset]\n", diff.Address)))
+	case jsonformat.ActionUpdate:
+		buf.WriteString(color.Color(fmt.Sprintf("  [bold]#[reset] %s [bold]will be updated in-place[reset]\n", diff.Address)))
+	default:
+		buf.WriteString(color.Color(fmt.Sprintf("  [bold]#[reset] %s\n", diff.Address)))
+	}
+
+	buf.WriteString(color.Color(fmt.Sprintf("%s resource %q %q {\n", blockMarker(diff.Action), diff.Type, diff.Name)))
+	writeChangeChildren(&buf, diff.Change.Attributes, 6, color)
+	buf.WriteString("    }\n")
+
+	return buf.String()
+}
+
+// blockMarker returns the symbol rendered immediately before the resource
+// block header for a given action, e.g. "+" for create.
+func blockMarker(action jsonformat.Action) string {
+	switch action {
+	case jsonformat.ActionCreate:
+		return "  [green]+[reset]"
+	case jsonformat.ActionDelete:
+		return "  [red]-[reset]"
+	case jsonformat.ActionReplace:
+		return "[red]-[reset]/[green]+[reset]"
+	case jsonformat.ActionUpdate:
+		return "  [yellow]~[reset]"
+	default:
+		return "   "
+	}
+}
+
+// writeChangeChildren renders a set of sibling attribute/block changes,
+// aligning the "=" signs of the leaf attributes among them on the longest
+// leaf name. Nested blocks and collections don't participate in that
+// alignment; each gets its own braced sub-block instead.
+func writeChangeChildren(buf *bytes.Buffer, children []*jsonformat.Change, indent int, color *colorstring.Colorize) {
+	sorted := append([]*jsonformat.Change(nil), children...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	maxLen := 0
+	for _, c := range sorted {
+		if isLeafChange(c) && len(c.Name) > maxLen {
+			maxLen = len(c.Name)
+		}
+	}
+
+	for _, c := range sorted {
+		switch {
+		case c.Sensitive:
+			// A sensitive value is always a redacted leaf line, even if a
+			// future change to the tree builder ever left Attributes or
+			// Elements populated alongside it: never recurse into either.
+			writeChange(buf, c.Name, c, indent, maxLen, color)
+		case c.Elements != nil:
+			writeCollection(buf, c, indent, color)
+		case c.Attributes != nil:
+			writeObjectBlock(buf, c.Name, c, indent, color)
+		default:
+			writeChange(buf, c.Name, c, indent, maxLen, color)
+		}
+	}
+}
+
+func isLeafChange(c *jsonformat.Change) bool {
+	return c.Sensitive || (c.Attributes == nil && c.Elements == nil)
+}
+
+func writeChange(buf *bytes.Buffer, name string, c *jsonformat.Change, indent, maxLen int, color *colorstring.Colorize) {
+	line := fmt.Sprintf("%*s%s%-*s = %s", indent, "", changeMarker(c.Action), maxLen, name, changeValue(c))
+	buf.WriteString(color.Color(forcesReplacement(line, c) + "\n"))
+}
+
+// writeObjectBlock renders a NestingSingle/NestingGroup nested block, or an
+// object-typed attribute, as its own braced sub-block. A literal config
+// block opens with a bare "name {", matching how it would be written in
+// HCL; an object-typed attribute value instead opens with "name = {" to
+// show it's the value being assigned to an attribute, not a block.
+func writeObjectBlock(buf *bytes.Buffer, label string, c *jsonformat.Change, indent int, color *colorstring.Colorize) {
+	open := " {"
+	if !c.IsBlock {
+		open = " = {"
+	}
+	header := fmt.Sprintf("%*s%s%s%s", indent, "", changeMarker(c.Action), label, open)
+	buf.WriteString(color.Color(forcesReplacement(header, c) + "\n"))
+	writeChangeChildren(buf, c.Attributes, indent+4, color)
+	buf.WriteString(fmt.Sprintf("%*s}\n", indent, ""))
+}
+
+// writeCollection renders a NestingList/NestingSet/NestingMap block, or a
+// list/set/map-of-object attribute, as a run of per-element sub-blocks,
+// collapsing consecutive unchanged elements behind a single
+// "# (N unchanged ... hidden)" summary line.
+//
+// A literal config block repeats its own name before each element ("name {",
+// or "name \"key\" {" for a map), the way each instance would be written out
+// in HCL. A list/set/map-of-object attribute instead has a single "name =
+// [...]" (or "{...}" for a map) wrapping anonymous per-element braces, since
+// there's no block label to repeat.
+func writeCollection(buf *bytes.Buffer, c *jsonformat.Change, indent int, color *colorstring.Colorize) {
+	elements := c.Elements
+	isBlock := len(elements) > 0 && elements[0].IsBlock
+
+	noun := "block"
+	if !isBlock {
+		noun = "element"
+	}
+
+	elemIndent := indent
+	if !isBlock {
+		open, close := "[", "]"
+		if collectionIsMap(elements) {
+			open, close = "{", "}"
+		}
+		buf.WriteString(color.Color(fmt.Sprintf("%*s%s%s = %s\n", indent, "", changeMarker(c.Action), c.Name, open)))
+		elemIndent = indent + 4
+		defer buf.WriteString(fmt.Sprintf("%*s%s\n", indent, "", close))
+	}
+
+	for i := 0; i < len(elements); {
+		if elements[i].Action == jsonformat.ActionNoOp {
+			j := i
+			for j < len(elements) && elements[j].Action == jsonformat.ActionNoOp {
+				j++
+			}
+			n := j - i
+			plural := "s"
+			if n == 1 {
+				plural = ""
+			}
+			buf.WriteString(fmt.Sprintf("%*s# (%d unchanged %s%s hidden)\n", elemIndent+4, "", n, noun, plural))
+			i = j
+			continue
+		}
+
+		if isBlock {
+			label := c.Name
+			if elements[i].ElementKey != "" {
+				label = fmt.Sprintf("%s %q", c.Name, elements[i].ElementKey)
+			}
+			writeObjectBlock(buf, label, elements[i], indent, color)
+		} else {
+			label := ""
+			if elements[i].ElementKey != "" {
+				label = fmt.Sprintf("%q = ", elements[i].ElementKey)
+			}
+			writeCollectionElement(buf, label, elements[i], elemIndent, color)
+		}
+		i++
+	}
+}
+
+// collectionIsMap reports whether elements came from a NestingMap block or a
+// map-of-object attribute, as opposed to a list or set.
+func collectionIsMap(elements []*jsonformat.Change) bool {
+	for _, e := range elements {
+		if e.ElementKey != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeCollectionElement renders one element of a list/set/map-of-object
+// attribute: an anonymous braced value (or, for a map, "key = {...}"), as
+// opposed to the named "name {" block writeObjectBlock uses for a literal
+// nested block.
+func writeCollectionElement(buf *bytes.Buffer, label string, c *jsonformat.Change, indent int, color *colorstring.Colorize) {
+	header := fmt.Sprintf("%*s%s%s{", indent, "", changeMarker(c.Action), label)
+	buf.WriteString(color.Color(forcesReplacement(header, c) + "\n"))
+	writeChangeChildren(buf, c.Attributes, indent+4, color)
+	buf.WriteString(fmt.Sprintf("%*s},\n", indent, ""))
+}
+
+// forcesReplacement appends the "# forces replacement" annotation to line
+// when c's path is the one that required the resource to be replaced.
+func forcesReplacement(line string, c *jsonformat.Change) string {
+	if c.ForcesReplacement {
+		return line + " # forces replacement"
+	}
+	return line
+}
+
+func changeMarker(action jsonformat.Action) string {
+	switch action {
+	case jsonformat.ActionCreate:
+		return "[green]+[reset] "
+	case jsonformat.ActionDelete:
+		return "[red]-[reset] "
+	case jsonformat.ActionUpdate, jsonformat.ActionReplace:
+		return "[yellow]~[reset] "
+	default:
+		return "  "
+	}
+}
+
+// changeValue renders the value portion of a diff line. Sensitivity takes
+// priority over every other concern: a marked attribute's real value is
+// never printed, whether it was created, deleted, updated, or is still
+// unknown-after-apply. The one extra thing a sensitive value needs to
+// convey is when sensitivity itself is what changed (the underlying value
+// is identical), which gets a trailing "# sensitive value" comment since
+// there's otherwise nothing to show the user.
+func changeValue(c *jsonformat.Change) string {
+	if c.Sensitive {
+		const value = "(sensitive value)"
+		switch {
+		case c.Unknown && c.Action == jsonformat.ActionCreate:
+			return value
+		case c.Unknown:
+			return fmt.Sprintf("%s -> (known after apply)", value)
+		case c.SensitivityChanged && c.Before.RawEquals(c.After):
+			return value + " # sensitive value"
+		default:
+			return value
+		}
+	}
+
+	if c.Unknown {
+		if c.Action == jsonformat.ActionNoOp || c.Action == jsonformat.ActionCreate {
+			return "(known after apply)"
+		}
+		return fmt.Sprintf("%s -> (known after apply)", valueString(c.Before))
+	}
+
+	switch c.Action {
+	case jsonformat.ActionNoOp:
+		return valueString(c.Before)
+	case jsonformat.ActionCreate:
+		return valueString(c.After)
+	case jsonformat.ActionDelete:
+		return fmt.Sprintf("%s -> null", valueString(c.Before))
+	default:
+		return fmt.Sprintf("%s -> %s", valueString(c.Before), valueString(c.After))
+	}
+}
+
+// valueString renders a leaf cty.Value the way it should appear on either
+// side of a diff line. Primitive values are rendered in HCL-like form.
+// Collections of primitives are leaves of the Change tree too, and reach
+// here to be printed in cty's Go syntax; object types and collections of
+// objects are rendered by recursing through the Change tree instead.
+func valueString(v cty.Value) string {
+	if v.IsNull() {
+		return "null"
+	}
+	if !v.IsKnown() {
+		return "(known after apply)"
+	}
+
+	ty := v.Type()
+	switch {
+	case ty == cty.String:
+		return fmt.Sprintf("%q", v.AsString())
+	case ty == cty.Number:
+		bf := v.AsBigFloat()
+		return bf.Text('f', -1)
+	case ty == cty.Bool:
+		if v.True() {
+			return "true"
+		}
+		return "false"
+	default:
+		return fmt.Sprintf("%#v", v)
+	}
+}
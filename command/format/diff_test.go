@@ -5,6 +5,7 @@ import (
 
 	"github.com/hashicorp/terraform/addrs"
 	"github.com/hashicorp/terraform/configs/configschema"
+	"github.com/hashicorp/terraform/lang/marks"
 	"github.com/hashicorp/terraform/plans"
 	"github.com/mitchellh/colorstring"
 	"github.com/zclconf/go-cty/cty"
@@ -16,6 +17,8 @@ func TestResourceChange(t *testing.T) {
 		Mode            addrs.ResourceMode
 		Before          cty.Value
 		After           cty.Value
+		BeforeValMarks  []cty.PathValueMarks
+		AfterValMarks   []cty.PathValueMarks
 		Schema          *configschema.Block
 		RequiredReplace cty.PathSet
 		ExpectedOutput  string
@@ -108,6 +111,473 @@ func TestResourceChange(t *testing.T) {
       ~ ami = "ami-BEFORE" -> "ami-AFTER"
       ~ id  = "i-02ae66f368e8518a9" -> (known after apply)
     }
+`,
+		},
+		"update with attribute marked sensitive in schema": {
+			Action: plans.Update,
+			Mode:   addrs.ManagedResourceMode,
+			Before: cty.ObjectVal(map[string]cty.Value{
+				"password": cty.StringVal("top-secret"),
+			}),
+			After: cty.ObjectVal(map[string]cty.Value{
+				"password": cty.StringVal("even-more-secret"),
+			}),
+			Schema: &configschema.Block{
+				Attributes: map[string]*configschema.Attribute{
+					"password": {Type: cty.String, Optional: true, Sensitive: true},
+				},
+			},
+			RequiredReplace: cty.NewPathSet(),
+			ExpectedOutput: `  # test_instance.example will be updated in-place
+  ~ resource "test_instance" "example" {
+      ~ password = (sensitive value)
+    }
+`,
+		},
+		"update with value marked sensitive at runtime": {
+			Action: plans.Update,
+			Mode:   addrs.ManagedResourceMode,
+			Before: cty.ObjectVal(map[string]cty.Value{
+				"token": cty.StringVal("same-token"),
+			}),
+			After: cty.ObjectVal(map[string]cty.Value{
+				"token": cty.StringVal("same-token"),
+			}),
+			BeforeValMarks: []cty.PathValueMarks{
+				{
+					Path:  cty.Path{cty.GetAttrStep{Name: "token"}},
+					Marks: cty.NewValueMarks(marks.Sensitive),
+				},
+			},
+			Schema: &configschema.Block{
+				Attributes: map[string]*configschema.Attribute{
+					"token": {Type: cty.String, Optional: true},
+				},
+			},
+			RequiredReplace: cty.NewPathSet(),
+			ExpectedOutput: `  # test_instance.example will be updated in-place
+  ~ resource "test_instance" "example" {
+      ~ token = (sensitive value) # sensitive value
+    }
+`,
+		},
+		"update with a nested sensitive object attribute": {
+			Action: plans.Update,
+			Mode:   addrs.ManagedResourceMode,
+			Before: cty.ObjectVal(map[string]cty.Value{
+				"network": cty.ObjectVal(map[string]cty.Value{
+					"subnet_id": cty.StringVal("subnet-1"),
+				}),
+			}),
+			After: cty.ObjectVal(map[string]cty.Value{
+				"network": cty.ObjectVal(map[string]cty.Value{
+					"subnet_id": cty.StringVal("subnet-2"),
+				}),
+			}),
+			Schema: &configschema.Block{
+				Attributes: map[string]*configschema.Attribute{
+					"network": {
+						Type:      cty.Object(map[string]cty.Type{"subnet_id": cty.String}),
+						Optional:  true,
+						Sensitive: true,
+					},
+				},
+			},
+			RequiredReplace: cty.NewPathSet(),
+			ExpectedOutput: `  # test_instance.example will be updated in-place
+  ~ resource "test_instance" "example" {
+      ~ network = (sensitive value)
+    }
+`,
+		},
+		"force-new update with a sensitive value that is also unknown": {
+			Action: plans.DeleteThenCreate,
+			Mode:   addrs.ManagedResourceMode,
+			Before: cty.ObjectVal(map[string]cty.Value{
+				"password": cty.StringVal("top-secret"),
+			}),
+			After: cty.ObjectVal(map[string]cty.Value{
+				"password": cty.UnknownVal(cty.String),
+			}),
+			Schema: &configschema.Block{
+				Attributes: map[string]*configschema.Attribute{
+					"password": {Type: cty.String, Optional: true, Computed: true, Sensitive: true},
+				},
+			},
+			RequiredReplace: cty.NewPathSet(cty.Path{
+				cty.GetAttrStep{Name: "password"},
+			}),
+			ExpectedOutput: `  # test_instance.example must be replaced
+-/+ resource "test_instance" "example" {
+      ~ password = (sensitive value) -> (known after apply) # forces replacement
+    }
+`,
+		},
+		"force-new update with a nested block forcing replacement": {
+			Action: plans.DeleteThenCreate,
+			Mode:   addrs.ManagedResourceMode,
+			Before: cty.ObjectVal(map[string]cty.Value{
+				"id": cty.StringVal("i-xyz"),
+				"network_interface": cty.ListVal([]cty.Value{
+					cty.ObjectVal(map[string]cty.Value{"subnet_id": cty.StringVal("subnet-0")}),
+					cty.ObjectVal(map[string]cty.Value{"subnet_id": cty.StringVal("subnet-1")}),
+					cty.ObjectVal(map[string]cty.Value{"subnet_id": cty.StringVal("subnet-2")}),
+				}),
+			}),
+			After: cty.ObjectVal(map[string]cty.Value{
+				"id": cty.StringVal("i-xyz"),
+				"network_interface": cty.ListVal([]cty.Value{
+					cty.ObjectVal(map[string]cty.Value{"subnet_id": cty.StringVal("subnet-0")}),
+					cty.ObjectVal(map[string]cty.Value{"subnet_id": cty.StringVal("subnet-1-new")}),
+					cty.ObjectVal(map[string]cty.Value{"subnet_id": cty.StringVal("subnet-2")}),
+				}),
+			}),
+			Schema: &configschema.Block{
+				Attributes: map[string]*configschema.Attribute{
+					"id": {Type: cty.String, Computed: true},
+				},
+				BlockTypes: map[string]*configschema.NestedBlock{
+					"network_interface": {
+						Nesting: configschema.NestingList,
+						Block: configschema.Block{
+							Attributes: map[string]*configschema.Attribute{
+								"subnet_id": {Type: cty.String, Optional: true},
+							},
+						},
+					},
+				},
+			},
+			RequiredReplace: cty.NewPathSet(cty.Path{
+				cty.GetAttrStep{Name: "network_interface"},
+				cty.IndexStep{Key: cty.NumberIntVal(1)},
+				cty.GetAttrStep{Name: "subnet_id"},
+			}),
+			ExpectedOutput: `  # test_instance.example must be replaced
+-/+ resource "test_instance" "example" {
+        id = "i-xyz"
+          # (1 unchanged block hidden)
+      ~ network_interface {
+          ~ subnet_id = "subnet-1" -> "subnet-1-new" # forces replacement
+      }
+          # (1 unchanged block hidden)
+    }
+`,
+		},
+		"update with a list-of-objects attribute": {
+			Action: plans.Update,
+			Mode:   addrs.ManagedResourceMode,
+			Before: cty.ObjectVal(map[string]cty.Value{
+				"tags": cty.ListVal([]cty.Value{
+					cty.ObjectVal(map[string]cty.Value{"key": cty.StringVal("env"), "value": cty.StringVal("staging")}),
+				}),
+			}),
+			After: cty.ObjectVal(map[string]cty.Value{
+				"tags": cty.ListVal([]cty.Value{
+					cty.ObjectVal(map[string]cty.Value{"key": cty.StringVal("env"), "value": cty.StringVal("production")}),
+				}),
+			}),
+			Schema: &configschema.Block{
+				Attributes: map[string]*configschema.Attribute{
+					"tags": {
+						Type:     cty.List(cty.Object(map[string]cty.Type{"key": cty.String, "value": cty.String})),
+						Optional: true,
+					},
+				},
+			},
+			RequiredReplace: cty.NewPathSet(),
+			ExpectedOutput: `  # test_instance.example will be updated in-place
+  ~ resource "test_instance" "example" {
+      ~ tags = [
+          ~ {
+                key   = "env"
+              ~ value = "staging" -> "production"
+          },
+      ]
+    }
+`,
+		},
+		"update with a nested single block": {
+			Action: plans.Update,
+			Mode:   addrs.ManagedResourceMode,
+			Before: cty.ObjectVal(map[string]cty.Value{
+				"timeouts": cty.ObjectVal(map[string]cty.Value{
+					"create": cty.StringVal("10m"),
+				}),
+			}),
+			After: cty.ObjectVal(map[string]cty.Value{
+				"timeouts": cty.ObjectVal(map[string]cty.Value{
+					"create": cty.StringVal("20m"),
+				}),
+			}),
+			Schema: &configschema.Block{
+				BlockTypes: map[string]*configschema.NestedBlock{
+					"timeouts": {
+						Nesting: configschema.NestingSingle,
+						Block: configschema.Block{
+							Attributes: map[string]*configschema.Attribute{
+								"create": {Type: cty.String, Optional: true},
+							},
+						},
+					},
+				},
+			},
+			RequiredReplace: cty.NewPathSet(),
+			ExpectedOutput: `  # test_instance.example will be updated in-place
+  ~ resource "test_instance" "example" {
+      ~ timeouts {
+          ~ create = "10m" -> "20m"
+      }
+    }
+`,
+		},
+		"update with a nested group block": {
+			Action: plans.Update,
+			Mode:   addrs.ManagedResourceMode,
+			Before: cty.ObjectVal(map[string]cty.Value{
+				"lifecycle_rule": cty.ObjectVal(map[string]cty.Value{
+					"enabled": cty.False,
+				}),
+			}),
+			After: cty.ObjectVal(map[string]cty.Value{
+				"lifecycle_rule": cty.ObjectVal(map[string]cty.Value{
+					"enabled": cty.True,
+				}),
+			}),
+			Schema: &configschema.Block{
+				BlockTypes: map[string]*configschema.NestedBlock{
+					"lifecycle_rule": {
+						Nesting: configschema.NestingGroup,
+						Block: configschema.Block{
+							Attributes: map[string]*configschema.Attribute{
+								"enabled": {Type: cty.Bool, Optional: true},
+							},
+						},
+					},
+				},
+			},
+			RequiredReplace: cty.NewPathSet(),
+			ExpectedOutput: `  # test_instance.example will be updated in-place
+  ~ resource "test_instance" "example" {
+      ~ lifecycle_rule {
+          ~ enabled = false -> true
+      }
+    }
+`,
+		},
+		"update with a nested set block": {
+			Action: plans.Update,
+			Mode:   addrs.ManagedResourceMode,
+			Before: cty.ObjectVal(map[string]cty.Value{
+				"ingress": cty.SetVal([]cty.Value{
+					cty.ObjectVal(map[string]cty.Value{
+						"port": cty.NumberIntVal(22),
+						"cidr": cty.StringVal("10.0.0.0/8"),
+					}),
+				}),
+			}),
+			After: cty.ObjectVal(map[string]cty.Value{
+				"ingress": cty.SetVal([]cty.Value{
+					cty.ObjectVal(map[string]cty.Value{
+						"port": cty.NumberIntVal(22),
+						"cidr": cty.StringVal("10.0.0.0/8"),
+					}),
+					cty.ObjectVal(map[string]cty.Value{
+						"port": cty.NumberIntVal(443),
+						"cidr": cty.StringVal("0.0.0.0/0"),
+					}),
+				}),
+			}),
+			Schema: &configschema.Block{
+				BlockTypes: map[string]*configschema.NestedBlock{
+					"ingress": {
+						Nesting: configschema.NestingSet,
+						Block: configschema.Block{
+							Attributes: map[string]*configschema.Attribute{
+								"port": {Type: cty.Number, Optional: true},
+								"cidr": {Type: cty.String, Optional: true},
+							},
+						},
+					},
+				},
+			},
+			RequiredReplace: cty.NewPathSet(),
+			ExpectedOutput: `  # test_instance.example will be updated in-place
+  ~ resource "test_instance" "example" {
+          # (1 unchanged block hidden)
+      + ingress {
+          + cidr = "0.0.0.0/0"
+          + port = 443
+      }
+    }
+`,
+		},
+		"update with a nested map block": {
+			Action: plans.Update,
+			Mode:   addrs.ManagedResourceMode,
+			Before: cty.ObjectVal(map[string]cty.Value{
+				"disk": cty.MapVal(map[string]cty.Value{
+					"root": cty.ObjectVal(map[string]cty.Value{"size": cty.NumberIntVal(20)}),
+				}),
+			}),
+			After: cty.ObjectVal(map[string]cty.Value{
+				"disk": cty.MapVal(map[string]cty.Value{
+					"root": cty.ObjectVal(map[string]cty.Value{"size": cty.NumberIntVal(40)}),
+				}),
+			}),
+			Schema: &configschema.Block{
+				BlockTypes: map[string]*configschema.NestedBlock{
+					"disk": {
+						Nesting: configschema.NestingMap,
+						Block: configschema.Block{
+							Attributes: map[string]*configschema.Attribute{
+								"size": {Type: cty.Number, Optional: true},
+							},
+						},
+					},
+				},
+			},
+			RequiredReplace: cty.NewPathSet(),
+			ExpectedOutput: `  # test_instance.example will be updated in-place
+  ~ resource "test_instance" "example" {
+      ~ disk "root" {
+          ~ size = 20 -> 40
+      }
+    }
+`,
+		},
+	}
+
+	color := &colorstring.Colorize{Colors: colorstring.DefaultColors, Disable: true}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			beforeVal := tc.Before
+			before, err := plans.NewDynamicValue(beforeVal, beforeVal.Type())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			afterVal := tc.After
+			after, err := plans.NewDynamicValue(afterVal, afterVal.Type())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			change := &plans.ResourceInstanceChangeSrc{
+				Addr: addrs.Resource{
+					Mode: tc.Mode,
+					Type: "test_instance",
+					Name: "example",
+				}.Instance(addrs.NoKey).Absolute(addrs.RootModuleInstance),
+				ProviderAddr: addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance),
+				ChangeSrc: plans.ChangeSrc{
+					Action:         tc.Action,
+					Before:         before,
+					After:          after,
+					BeforeValMarks: tc.BeforeValMarks,
+					AfterValMarks:  tc.AfterValMarks,
+				},
+				RequiredReplace: tc.RequiredReplace,
+			}
+
+			output := ResourceChange(change, tc.Schema, color)
+			if output != tc.ExpectedOutput {
+				t.Fatalf("Unexpected diff.\nExpected:\n%s\nGiven:\n%s\n", tc.ExpectedOutput, output)
+			}
+		})
+	}
+}
+
+func TestResourceChangeFiltered(t *testing.T) {
+	testCases := map[string]struct {
+		Action          plans.Action
+		Mode            addrs.ResourceMode
+		Before          cty.Value
+		After           cty.Value
+		Schema          *configschema.Block
+		RequiredReplace cty.PathSet
+		Paths           []cty.Path
+		ExpectedOutput  string
+	}{
+		"filtered to a single sibling attribute": {
+			Action: plans.Update,
+			Mode:   addrs.ManagedResourceMode,
+			Before: cty.ObjectVal(map[string]cty.Value{
+				"id":  cty.StringVal("i-02ae66f368e8518a9"),
+				"ami": cty.StringVal("ami-BEFORE"),
+			}),
+			After: cty.ObjectVal(map[string]cty.Value{
+				"id":  cty.StringVal("i-02ae66f368e8518a9"),
+				"ami": cty.StringVal("ami-AFTER"),
+			}),
+			Schema: &configschema.Block{
+				Attributes: map[string]*configschema.Attribute{
+					"id":  {Type: cty.String, Optional: true, Computed: true},
+					"ami": {Type: cty.String, Optional: true},
+				},
+			},
+			RequiredReplace: cty.NewPathSet(),
+			Paths: []cty.Path{
+				{cty.GetAttrStep{Name: "ami"}},
+			},
+			ExpectedOutput: `  # test_instance.example will be updated in-place
+  ~ resource "test_instance" "example" {
+      ~ ami = "ami-BEFORE" -> "ami-AFTER"
+    }
+`,
+		},
+		"filtered to a deeply nested attribute in a force-new plan": {
+			Action: plans.DeleteThenCreate,
+			Mode:   addrs.ManagedResourceMode,
+			Before: cty.ObjectVal(map[string]cty.Value{
+				"id": cty.StringVal("i-xyz"),
+				"network_interface": cty.ListVal([]cty.Value{
+					cty.ObjectVal(map[string]cty.Value{"subnet_id": cty.StringVal("subnet-0")}),
+					cty.ObjectVal(map[string]cty.Value{"subnet_id": cty.StringVal("subnet-1")}),
+					cty.ObjectVal(map[string]cty.Value{"subnet_id": cty.StringVal("subnet-2")}),
+				}),
+			}),
+			After: cty.ObjectVal(map[string]cty.Value{
+				"id": cty.StringVal("i-xyz"),
+				"network_interface": cty.ListVal([]cty.Value{
+					cty.ObjectVal(map[string]cty.Value{"subnet_id": cty.StringVal("subnet-0")}),
+					cty.ObjectVal(map[string]cty.Value{"subnet_id": cty.StringVal("subnet-1-new")}),
+					cty.ObjectVal(map[string]cty.Value{"subnet_id": cty.StringVal("subnet-2")}),
+				}),
+			}),
+			Schema: &configschema.Block{
+				Attributes: map[string]*configschema.Attribute{
+					"id": {Type: cty.String, Computed: true},
+				},
+				BlockTypes: map[string]*configschema.NestedBlock{
+					"network_interface": {
+						Nesting: configschema.NestingList,
+						Block: configschema.Block{
+							Attributes: map[string]*configschema.Attribute{
+								"subnet_id": {Type: cty.String, Optional: true},
+							},
+						},
+					},
+				},
+			},
+			RequiredReplace: cty.NewPathSet(cty.Path{
+				cty.GetAttrStep{Name: "network_interface"},
+				cty.IndexStep{Key: cty.NumberIntVal(1)},
+				cty.GetAttrStep{Name: "subnet_id"},
+			}),
+			Paths: []cty.Path{
+				{
+					cty.GetAttrStep{Name: "network_interface"},
+					cty.IndexStep{Key: cty.NumberIntVal(1)},
+					cty.GetAttrStep{Name: "subnet_id"},
+				},
+			},
+			ExpectedOutput: `  # test_instance.example must be replaced
+-/+ resource "test_instance" "example" {
+      ~ network_interface {
+          ~ subnet_id = "subnet-1" -> "subnet-1-new" # forces replacement
+      }
+    }
 `,
 		},
 	}
@@ -143,10 +613,10 @@ func TestResourceChange(t *testing.T) {
 				RequiredReplace: tc.RequiredReplace,
 			}
 
-			output := ResourceChange(change, tc.Schema, color)
+			output := ResourceChangeFiltered(change, tc.Schema, color, tc.Paths)
 			if output != tc.ExpectedOutput {
 				t.Fatalf("Unexpected diff.\nExpected:\n%s\nGiven:\n%s\n", tc.ExpectedOutput, output)
 			}
 		})
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,665 @@
+// Package jsonformat builds a structured, JSON-serializable representation
+// of a planned resource instance change.
+//
+// The structure produced here is the single source of truth for "what
+// changed and why" in a resource diff: command/format's text renderer walks
+// it to produce the colorized plan output, and external consumers (CI bots,
+// policy engines, editor integrations) can marshal it directly to JSON. The
+// two are guaranteed to agree because they're built from the same tree.
+package jsonformat
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/configs/configschema"
+	"github.com/hashicorp/terraform/lang/marks"
+	"github.com/hashicorp/terraform/plans"
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+)
+
+// Action is a JSON-stable description of what's happening to a value at a
+// particular path. It mirrors plans.Action but uses names that are safe to
+// depend on in external tooling, independent of how plans.Action is
+// numbered internally.
+type Action string
+
+const (
+	ActionNoOp    Action = "no-op"
+	ActionCreate  Action = "create"
+	ActionRead    Action = "read"
+	ActionUpdate  Action = "update"
+	ActionDelete  Action = "delete"
+	ActionReplace Action = "replace"
+)
+
+// Change describes the diff of a single attribute, nested block, or
+// collection element within a resource change.
+//
+// A Change is one of three shapes:
+//   - a leaf: Attributes and Elements are both nil, and Before/After hold
+//     the value directly.
+//   - an object (a NestingSingle/NestingGroup block, or an object-typed
+//     attribute): Attributes holds one Change per schema attribute/block
+//     or object type, keyed by Name.
+//   - a collection (a NestingList/NestingSet/NestingMap block, or a
+//     list/set/map-of-object attribute): Elements holds one Change per
+//     member, each itself an object Change.
+type Change struct {
+	// Path is the attribute path from the root of the resource.
+	Path cty.Path
+
+	// Name is the attribute or block name this change corresponds to,
+	// equivalent to the step at the end of Path. Empty for the root change.
+	// A collection element carries the name of its collection, since the
+	// step at the end of its Path is an index (see ElementKey for NestingMap
+	// members).
+	Name string
+
+	Action Action
+
+	// Before and After are always the unmarked form of the decoded values,
+	// so that renderers only need to consult Sensitive to decide whether
+	// it's safe to show a leaf's values. An object or collection Change's
+	// Before and After also hold its members' values, sensitive ones
+	// included, unredacted.
+	Before cty.Value
+	After  cty.Value
+
+	// Unknown is true when After is not yet wholly known, i.e. its value
+	// will only be known after apply.
+	Unknown bool
+
+	// Sensitive is true if this value is marked sensitive at runtime (via
+	// a cty marks.Sensitive mark, inherited from any containing value) or
+	// declared Sensitive in the provider schema. Renderers must not print
+	// Before or After when this is set.
+	Sensitive bool
+
+	// SensitivityChanged is true if the value was sensitive on one side of
+	// the change but not the other. It can be true even when Before and
+	// After are otherwise equal, since newly-marking a value sensitive is
+	// itself a change worth surfacing.
+	SensitivityChanged bool
+
+	// ForcesReplacement is true if this exact path is a member of the
+	// change's RequiredReplace path set.
+	ForcesReplacement bool
+
+	// IsBlock is true if this Change corresponds to a configschema.NestedBlock
+	// rather than a plain object/collection-typed attribute. For a
+	// NestingList/NestingSet/NestingMap block it is set on every member of
+	// Elements, not on the collection Change itself.
+	IsBlock bool
+
+	// ElementKey is the map key this Change was found under, when it's a
+	// member of a NestingMap block's or a map-typed attribute's Elements.
+	// Empty otherwise.
+	ElementKey string
+
+	Attributes []*Change
+	Elements   []*Change
+}
+
+// jsonChange is the wire shape Change.MarshalJSON produces. It exists
+// because Change's own fields aren't directly JSON-safe: Path is a
+// cty.Path, whose step types marshal to "{}", and Before/After are
+// cty.Value, which has no exported fields at all.
+type jsonChange struct {
+	Path               []interface{}   `json:"path"`
+	Name               string          `json:"name,omitempty"`
+	Action             Action          `json:"action"`
+	Before             json.RawMessage `json:"before,omitempty"`
+	After              json.RawMessage `json:"after,omitempty"`
+	Unknown            bool            `json:"unknown,omitempty"`
+	Sensitive          bool            `json:"sensitive,omitempty"`
+	SensitivityChanged bool            `json:"sensitivity_changed,omitempty"`
+	ForcesReplacement  bool            `json:"forces_replacement,omitempty"`
+	IsBlock            bool            `json:"is_block,omitempty"`
+	ElementKey         string          `json:"element_key,omitempty"`
+	Attributes         []*Change       `json:"attributes,omitempty"`
+	Elements           []*Change       `json:"elements,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler. Before and After are encoded with
+// ctyjson so that a caller with no access to the originating schema can
+// still recover their type. They're only serialized for a leaf Change: an
+// object or collection's values are carried by its Attributes or Elements,
+// and its own Before/After would repeat any sensitive members unredacted. A
+// sensitive Change never serializes either one, regardless of what values
+// happen to be stored in it.
+func (c *Change) MarshalJSON() ([]byte, error) {
+	path, err := marshalPath(c.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	out := jsonChange{
+		Path:               path,
+		Name:               c.Name,
+		Action:             c.Action,
+		Unknown:            c.Unknown,
+		Sensitive:          c.Sensitive,
+		SensitivityChanged: c.SensitivityChanged,
+		ForcesReplacement:  c.ForcesReplacement,
+		IsBlock:            c.IsBlock,
+		ElementKey:         c.ElementKey,
+		Attributes:         c.Attributes,
+		Elements:           c.Elements,
+	}
+
+	if !c.Sensitive && c.Attributes == nil && c.Elements == nil {
+		before, err := marshalCtyValue(c.Before)
+		if err != nil {
+			return nil, err
+		}
+		out.Before = before
+
+		after, err := marshalCtyValue(c.After)
+		if err != nil {
+			return nil, err
+		}
+		out.After = after
+	}
+
+	return json.Marshal(out)
+}
+
+// marshalCtyValue encodes v for inclusion in a jsonChange. A null or
+// not-yet-known value (the latter already surfaced to JSON consumers via
+// Change.Unknown) has no meaningful representation of its own, so it's
+// encoded as a plain JSON null rather than handed to ctyjson.
+func marshalCtyValue(v cty.Value) (json.RawMessage, error) {
+	if v.IsNull() || !v.IsKnown() {
+		return json.RawMessage("null"), nil
+	}
+	raw, err := ctyjson.Marshal(v, v.Type())
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(raw), nil
+}
+
+// marshalPath flattens a cty.Path into plain JSON-safe values: each
+// cty.GetAttrStep becomes its attribute name, and each cty.IndexStep
+// becomes its key (a number for list indices, a string for map keys). A
+// NestingSet block's IndexStep carries the whole element value as its key
+// (see diffSet's use of appendIndexStep) rather than a string or number, so
+// that case is encoded via marshalCtyValue instead of being dropped.
+func marshalPath(path cty.Path) ([]interface{}, error) {
+	steps := make([]interface{}, 0, len(path))
+	for _, step := range path {
+		switch step := step.(type) {
+		case cty.GetAttrStep:
+			steps = append(steps, step.Name)
+		case cty.IndexStep:
+			key := step.Key
+			switch {
+			case key.Type() == cty.String:
+				steps = append(steps, key.AsString())
+			case key.Type() == cty.Number:
+				f, _ := key.AsBigFloat().Float64()
+				steps = append(steps, f)
+			default:
+				raw, err := marshalCtyValue(key)
+				if err != nil {
+					return nil, err
+				}
+				steps = append(steps, raw)
+			}
+		}
+	}
+	return steps, nil
+}
+
+// ResourceChange is the root of a structured diff tree for a single
+// resource instance change.
+type ResourceChange struct {
+	Address string
+	Mode    addrs.ResourceMode
+	Type    string
+	Name    string
+	Action  Action
+	Change  *Change
+}
+
+// BuildResourceChange walks change against schema and produces the
+// structured diff tree consumed by both format.ResourceChange and any
+// JSON-based caller.
+func BuildResourceChange(change *plans.ResourceInstanceChangeSrc, schema *configschema.Block) (*ResourceChange, error) {
+	ty := schema.ImpliedType()
+
+	before, err := change.Before.Decode(ty)
+	if err != nil {
+		return nil, err
+	}
+	after, err := change.After.Decode(ty)
+	if err != nil {
+		return nil, err
+	}
+
+	// Runtime sensitivity marks don't survive the msgpack-backed DynamicValue
+	// round trip that produced Before/After, so ChangeSrc carries them
+	// separately and they have to be re-applied to the decoded values here.
+	before = before.MarkWithPaths(change.BeforeValMarks)
+	after = after.MarkWithPaths(change.AfterValMarks)
+
+	root := buildBlockChange(nil, "", schema, before, after, false, false, change.RequiredReplace)
+
+	resourceAddr := change.Addr.Resource.Resource
+
+	return &ResourceChange{
+		Address: change.Addr.String(),
+		Mode:    resourceAddr.Mode,
+		Type:    resourceAddr.Type,
+		Name:    resourceAddr.Name,
+		Action:  actionForPlansAction(change.Action),
+		Change:  root,
+	}, nil
+}
+
+func actionForPlansAction(action plans.Action) Action {
+	switch action {
+	case plans.NoOp:
+		return ActionNoOp
+	case plans.Create:
+		return ActionCreate
+	case plans.Read:
+		return ActionRead
+	case plans.Update:
+		return ActionUpdate
+	case plans.Delete:
+		return ActionDelete
+	case plans.DeleteThenCreate, plans.CreateThenDelete:
+		return ActionReplace
+	default:
+		return ActionNoOp
+	}
+}
+
+// buildBlockChange produces the Change for an object-typed value (the
+// resource's top-level attributes, a NestingSingle/NestingGroup nested
+// block, or one member of a list/set/map of blocks), recursing into each of
+// the block's declared attributes and nested block types.
+//
+// beforeSensitive and afterSensitive report whether the caller's before/after
+// object values were themselves marked sensitive, so that sensitivity can be
+// inherited by every attribute underneath regardless of whether the provider
+// schema also marks them individually.
+func buildBlockChange(path cty.Path, name string, schema *configschema.Block, before, after cty.Value, beforeSensitive, afterSensitive bool, replace cty.PathSet) *Change {
+	rawBefore, beforeMarked := unmarkSensitive(before)
+	rawAfter, afterMarked := unmarkSensitive(after)
+	beforeSensitive = beforeSensitive || beforeMarked
+	afterSensitive = afterSensitive || afterMarked
+
+	// As in buildValueChange, a block that's itself sensitive (inherited
+	// from a containing attribute) is a single redacted leaf: its
+	// attributes must not be expanded into the tree.
+	if beforeSensitive || afterSensitive {
+		return &Change{
+			Path:               path,
+			Name:               name,
+			Action:             leafAction(rawBefore, rawAfter, beforeSensitive != afterSensitive),
+			Before:             rawBefore,
+			After:              rawAfter,
+			Unknown:            !rawAfter.IsNull() && !rawAfter.IsWhollyKnown(),
+			Sensitive:          true,
+			SensitivityChanged: beforeSensitive != afterSensitive,
+			ForcesReplacement:  replace.Has(path),
+		}
+	}
+
+	children := make([]*Change, 0, len(schema.Attributes)+len(schema.BlockTypes))
+	for attrName, attrS := range schema.Attributes {
+		attrBefore := objAttr(rawBefore, attrName, attrS.Type)
+		attrAfter := objAttr(rawAfter, attrName, attrS.Type)
+		childPath := appendGetAttrStep(path, attrName)
+		children = append(children, buildValueChange(childPath, attrName, attrS.Type, attrBefore, attrAfter,
+			beforeSensitive || attrS.Sensitive, afterSensitive || attrS.Sensitive, replace))
+	}
+	for blockName, blockS := range schema.BlockTypes {
+		childPath := appendGetAttrStep(path, blockName)
+		children = append(children, buildNestedBlockChange(childPath, blockName, blockS, rawBefore, rawAfter, beforeSensitive, afterSensitive, replace))
+	}
+
+	return &Change{
+		Path:               path,
+		Name:               name,
+		Action:             leafAction(rawBefore, rawAfter, beforeSensitive != afterSensitive),
+		Before:             rawBefore,
+		After:              rawAfter,
+		Unknown:            !rawAfter.IsNull() && !rawAfter.IsWhollyKnown(),
+		Sensitive:          beforeSensitive || afterSensitive,
+		SensitivityChanged: beforeSensitive != afterSensitive,
+		ForcesReplacement:  replace.Has(path),
+		Attributes:         children,
+	}
+}
+
+// buildValueChange diffs a single value of type ty, recursing into object,
+// list, set, and map types so that structural attributes get the same
+// per-element diff treatment as nested blocks. Anything else (primitives,
+// and collections of primitives) is treated as an opaque leaf.
+func buildValueChange(path cty.Path, name string, ty cty.Type, before, after cty.Value, beforeSensitive, afterSensitive bool, replace cty.PathSet) *Change {
+	rawBefore, beforeMarked := unmarkSensitive(before)
+	rawAfter, afterMarked := unmarkSensitive(after)
+	beforeSensitive = beforeSensitive || beforeMarked
+	afterSensitive = afterSensitive || afterMarked
+
+	recurse := func(p cty.Path, elemTy cty.Type, b, a cty.Value, bs, as bool) *Change {
+		return buildValueChange(p, name, elemTy, b, a, bs, as, replace)
+	}
+
+	// A sensitive object/list/set/map is rendered as a single redacted leaf,
+	// not expanded into its structure: recursing further would leak field
+	// names (and, for any nested value whose own marking differs, partial
+	// structure) even though the value as a whole must not be shown.
+	if !beforeSensitive && !afterSensitive {
+		switch {
+		case ty.IsObjectType():
+			return buildObjectValueChange(path, name, ty, rawBefore, rawAfter, beforeSensitive, afterSensitive, replace)
+		case ty.IsListType() && ty.ElementType().IsObjectType():
+			return diffList(path, name, ty.ElementType(), rawBefore, rawAfter, beforeSensitive, afterSensitive, replace, recurse)
+		case ty.IsSetType() && ty.ElementType().IsObjectType():
+			return diffSet(path, name, ty.ElementType(), rawBefore, rawAfter, beforeSensitive, afterSensitive, replace, recurse)
+		case ty.IsMapType() && ty.ElementType().IsObjectType():
+			return diffMap(path, name, ty.ElementType(), rawBefore, rawAfter, beforeSensitive, afterSensitive, replace, recurse)
+		}
+	}
+
+	return &Change{
+		Path:               path,
+		Name:               name,
+		Action:             leafAction(rawBefore, rawAfter, beforeSensitive != afterSensitive),
+		Before:             rawBefore,
+		After:              rawAfter,
+		Unknown:            !rawAfter.IsNull() && !rawAfter.IsWhollyKnown(),
+		Sensitive:          beforeSensitive || afterSensitive,
+		SensitivityChanged: beforeSensitive != afterSensitive,
+		ForcesReplacement:  replace.Has(path),
+	}
+}
+
+func buildObjectValueChange(path cty.Path, name string, ty cty.Type, before, after cty.Value, beforeSensitive, afterSensitive bool, replace cty.PathSet) *Change {
+	attrTypes := ty.AttributeTypes()
+	children := make([]*Change, 0, len(attrTypes))
+	for attrName, attrTy := range attrTypes {
+		b := objAttr(before, attrName, attrTy)
+		a := objAttr(after, attrName, attrTy)
+		children = append(children, buildValueChange(appendGetAttrStep(path, attrName), attrName, attrTy, b, a, beforeSensitive, afterSensitive, replace))
+	}
+
+	return &Change{
+		Path:               path,
+		Name:               name,
+		Action:             leafAction(before, after, beforeSensitive != afterSensitive),
+		Before:             before,
+		After:              after,
+		Unknown:            !after.IsNull() && !after.IsWhollyKnown(),
+		Sensitive:          beforeSensitive || afterSensitive,
+		SensitivityChanged: beforeSensitive != afterSensitive,
+		ForcesReplacement:  replace.Has(path),
+		Attributes:         children,
+	}
+}
+
+// buildNestedBlockChange dispatches to the right shape of Change for a
+// configschema.NestedBlock based on its Nesting mode: a single object for
+// NestingSingle/NestingGroup, or a collection of objects for
+// NestingList/NestingSet/NestingMap.
+func buildNestedBlockChange(path cty.Path, name string, blockS *configschema.NestedBlock, beforeObj, afterObj cty.Value, parentBeforeSensitive, parentAfterSensitive bool, replace cty.PathSet) *Change {
+	elemTy := blockS.Block.ImpliedType()
+	recurse := func(p cty.Path, _ cty.Type, b, a cty.Value, bs, as bool) *Change {
+		c := buildBlockChange(p, name, &blockS.Block, b, a, bs, as, replace)
+		c.IsBlock = true
+		return c
+	}
+
+	switch blockS.Nesting {
+	case configschema.NestingList:
+		before := objAttr(beforeObj, name, cty.List(elemTy))
+		after := objAttr(afterObj, name, cty.List(elemTy))
+		return diffList(path, name, elemTy, before, after, parentBeforeSensitive, parentAfterSensitive, replace, recurse)
+	case configschema.NestingSet:
+		before := objAttr(beforeObj, name, cty.Set(elemTy))
+		after := objAttr(afterObj, name, cty.Set(elemTy))
+		return diffSet(path, name, elemTy, before, after, parentBeforeSensitive, parentAfterSensitive, replace, recurse)
+	case configschema.NestingMap:
+		before := objAttr(beforeObj, name, cty.Map(elemTy))
+		after := objAttr(afterObj, name, cty.Map(elemTy))
+		return diffMap(path, name, elemTy, before, after, parentBeforeSensitive, parentAfterSensitive, replace, recurse)
+	default: // NestingSingle, NestingGroup
+		before := objAttr(beforeObj, name, elemTy)
+		after := objAttr(afterObj, name, elemTy)
+		return recurse(path, elemTy, before, after, parentBeforeSensitive, parentAfterSensitive)
+	}
+}
+
+// elemBuilder builds the Change for one element of a list/set/map
+// collection, given the path it lives at and its before/after values.
+type elemBuilder func(path cty.Path, elemTy cty.Type, before, after cty.Value, beforeSensitive, afterSensitive bool) *Change
+
+// diffList pairs up list elements by index: trailing elements present on
+// only one side are pure creates or deletes.
+func diffList(path cty.Path, name string, elemTy cty.Type, before, after cty.Value, beforeSensitive, afterSensitive bool, replace cty.PathSet, build elemBuilder) *Change {
+	beforeElems := collectionElems(before)
+	afterElems := collectionElems(after)
+
+	n := len(beforeElems)
+	if len(afterElems) > n {
+		n = len(afterElems)
+	}
+
+	elements := make([]*Change, 0, n)
+	for i := 0; i < n; i++ {
+		b := cty.NullVal(elemTy)
+		if i < len(beforeElems) {
+			b = beforeElems[i]
+		}
+		a := cty.NullVal(elemTy)
+		if i < len(afterElems) {
+			a = afterElems[i]
+		}
+		elemPath := appendIndexStep(path, cty.NumberIntVal(int64(i)))
+		elements = append(elements, build(elemPath, elemTy, b, a, beforeSensitive, afterSensitive))
+	}
+
+	return collectionChange(path, name, before, after, beforeSensitive, afterSensitive, replace, elements)
+}
+
+// diffSet matches set elements by equality: anything in before with no
+// equal partner in after is a delete, and vice versa for create. Sets have
+// no stable identity beyond value equality, so this is the best available
+// correlation without provider-specific hints.
+//
+// Matching, and the cty.IndexStep each element's path is built from, always
+// use the unmarked form of the element: RawEquals (like GetAttr) doesn't
+// tolerate marked values, and a cty.Path step holding one would later break
+// any marks-oblivious path comparison done on it (see unmarkSensitive).
+func diffSet(path cty.Path, name string, elemTy cty.Type, before, after cty.Value, beforeSensitive, afterSensitive bool, replace cty.PathSet, build elemBuilder) *Change {
+	beforeElems := collectionElems(before)
+	afterElems := collectionElems(after)
+
+	afterRaw := make([]cty.Value, len(afterElems))
+	for j, a := range afterElems {
+		afterRaw[j], _ = unmarkSensitive(a)
+	}
+
+	used := make([]bool, len(afterElems))
+	var elements []*Change
+	for _, b := range beforeElems {
+		bRaw, _ := unmarkSensitive(b)
+		match := -1
+		for j := range afterElems {
+			if !used[j] && bRaw.RawEquals(afterRaw[j]) {
+				match = j
+				break
+			}
+		}
+		a := cty.NullVal(elemTy)
+		if match >= 0 {
+			used[match] = true
+			a = afterElems[match]
+		}
+		elements = append(elements, build(appendIndexStep(path, bRaw), elemTy, b, a, beforeSensitive, afterSensitive))
+	}
+	for j, a := range afterElems {
+		if used[j] {
+			continue
+		}
+		elements = append(elements, build(appendIndexStep(path, afterRaw[j]), elemTy, cty.NullVal(elemTy), a, beforeSensitive, afterSensitive))
+	}
+
+	return collectionChange(path, name, before, after, beforeSensitive, afterSensitive, replace, elements)
+}
+
+// diffMap pairs map elements by key; keys present on only one side are pure
+// creates or deletes. Elements are emitted in sorted key order for stable
+// output.
+func diffMap(path cty.Path, name string, elemTy cty.Type, before, after cty.Value, beforeSensitive, afterSensitive bool, replace cty.PathSet, build elemBuilder) *Change {
+	beforeMap := collectionElemsByKey(before)
+	afterMap := collectionElemsByKey(after)
+
+	keys := make(map[string]bool, len(beforeMap)+len(afterMap))
+	for k := range beforeMap {
+		keys[k] = true
+	}
+	for k := range afterMap {
+		keys[k] = true
+	}
+	sortedKeys := make([]string, 0, len(keys))
+	for k := range keys {
+		sortedKeys = append(sortedKeys, k)
+	}
+	sort.Strings(sortedKeys)
+
+	elements := make([]*Change, 0, len(sortedKeys))
+	for _, k := range sortedKeys {
+		b, ok := beforeMap[k]
+		if !ok {
+			b = cty.NullVal(elemTy)
+		}
+		a, ok := afterMap[k]
+		if !ok {
+			a = cty.NullVal(elemTy)
+		}
+		child := build(appendIndexStep(path, cty.StringVal(k)), elemTy, b, a, beforeSensitive, afterSensitive)
+		child.ElementKey = k
+		elements = append(elements, child)
+	}
+
+	return collectionChange(path, name, before, after, beforeSensitive, afterSensitive, replace, elements)
+}
+
+// collectionChange assembles the Change for a list/set/map as a whole,
+// given its already-built Elements.
+func collectionChange(path cty.Path, name string, before, after cty.Value, beforeSensitive, afterSensitive bool, replace cty.PathSet, elements []*Change) *Change {
+	return &Change{
+		Path:               path,
+		Name:               name,
+		Action:             leafAction(before, after, beforeSensitive != afterSensitive),
+		Before:             before,
+		After:              after,
+		Unknown:            !after.IsNull() && !after.IsWhollyKnown(),
+		Sensitive:          beforeSensitive || afterSensitive,
+		SensitivityChanged: beforeSensitive != afterSensitive,
+		ForcesReplacement:  replace.Has(path),
+		Elements:           elements,
+	}
+}
+
+// objAttr fetches a named attribute out of obj, treating a null or unknown
+// object (as appears on the "before" side of a create, or the "after" side
+// of a delete) as if every attribute were null.
+func objAttr(obj cty.Value, name string, ty cty.Type) cty.Value {
+	if obj.IsNull() || !obj.IsKnown() {
+		return cty.NullVal(ty)
+	}
+	return obj.GetAttr(name)
+}
+
+// collectionElems returns the ordered members of a list or set value, or
+// nil if the collection itself is null or unknown.
+func collectionElems(v cty.Value) []cty.Value {
+	if v.IsNull() || !v.IsKnown() {
+		return nil
+	}
+	elems := make([]cty.Value, 0, v.LengthInt())
+	for it := v.ElementIterator(); it.Next(); {
+		_, ev := it.Element()
+		elems = append(elems, ev)
+	}
+	return elems
+}
+
+// collectionElemsByKey returns the members of a map value keyed by their
+// string key, or nil if the map itself is null or unknown.
+func collectionElemsByKey(v cty.Value) map[string]cty.Value {
+	if v.IsNull() || !v.IsKnown() {
+		return nil
+	}
+	elems := make(map[string]cty.Value, v.LengthInt())
+	for it := v.ElementIterator(); it.Next(); {
+		kv, ev := it.Element()
+		elems[kv.AsString()] = ev
+	}
+	return elems
+}
+
+// unmarkSensitive strips the marks.Sensitive mark from v, if present, so
+// that it's safe to pass to operations like GetAttr or RawEquals that don't
+// tolerate marked values. It reports whether the mark was present.
+func unmarkSensitive(v cty.Value) (cty.Value, bool) {
+	raw, valueMarks := v.Unmark()
+	_, sensitive := valueMarks[marks.Sensitive]
+	return raw, sensitive
+}
+
+// leafAction determines the per-value action for a before/after pair,
+// independent of the overall resource action. A resource being replaced,
+// for example, may still have individual attributes that didn't change.
+//
+// sensitivityChanged forces at least an update action even when the
+// underlying values are equal, since a value becoming (or ceasing to be)
+// sensitive is itself worth flagging to the user.
+func leafAction(before, after cty.Value, sensitivityChanged bool) Action {
+	beforeNull := before.IsNull()
+	afterNull := after.IsNull()
+
+	switch {
+	case beforeNull && afterNull:
+		if sensitivityChanged {
+			return ActionUpdate
+		}
+		return ActionNoOp
+	case beforeNull && !afterNull:
+		return ActionCreate
+	case !beforeNull && afterNull:
+		return ActionDelete
+	case !after.IsWhollyKnown():
+		return ActionUpdate
+	case before.RawEquals(after):
+		if sensitivityChanged {
+			return ActionUpdate
+		}
+		return ActionNoOp
+	default:
+		return ActionUpdate
+	}
+}
+
+func appendGetAttrStep(path cty.Path, attrName string) cty.Path {
+	next := make(cty.Path, len(path)+1)
+	copy(next, path)
+	next[len(path)] = cty.GetAttrStep{Name: attrName}
+	return next
+}
+
+func appendIndexStep(path cty.Path, key cty.Value) cty.Path {
+	next := make(cty.Path, len(path)+1)
+	copy(next, path)
+	next[len(path)] = cty.IndexStep{Key: key}
+	return next
+}
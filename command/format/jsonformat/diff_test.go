@@ -0,0 +1,709 @@
+package jsonformat
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/configs/configschema"
+	"github.com/hashicorp/terraform/lang/marks"
+	"github.com/hashicorp/terraform/plans"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestBuildResourceChange(t *testing.T) {
+	testCases := map[string]struct {
+		Action          plans.Action
+		Before          cty.Value
+		After           cty.Value
+		BeforeValMarks  []cty.PathValueMarks
+		AfterValMarks   []cty.PathValueMarks
+		Schema          *configschema.Block
+		RequiredReplace cty.PathSet
+
+		ExpectedAction             Action
+		ExpectedAttrs              map[string]Action
+		ExpectedUnknown            map[string]bool
+		ExpectedSensitive          map[string]bool
+		ExpectedSensitivityChanged map[string]bool
+	}{
+		"creation": {
+			Action: plans.Create,
+			Before: cty.NullVal(cty.EmptyObject),
+			After: cty.ObjectVal(map[string]cty.Value{
+				"id": cty.UnknownVal(cty.String),
+			}),
+			Schema: &configschema.Block{
+				Attributes: map[string]*configschema.Attribute{
+					"id": {Type: cty.String, Computed: true},
+				},
+			},
+			ExpectedAction:  ActionCreate,
+			ExpectedAttrs:   map[string]Action{"id": ActionCreate},
+			ExpectedUnknown: map[string]bool{"id": true},
+		},
+		"deletion": {
+			Action: plans.Delete,
+			Before: cty.ObjectVal(map[string]cty.Value{
+				"id": cty.StringVal("i-02ae66f368e8518a9"),
+			}),
+			After: cty.NullVal(cty.EmptyObject),
+			Schema: &configschema.Block{
+				Attributes: map[string]*configschema.Attribute{
+					"id": {Type: cty.String, Computed: true},
+				},
+			},
+			ExpectedAction: ActionDelete,
+			ExpectedAttrs:  map[string]Action{"id": ActionDelete},
+		},
+		"in-place update": {
+			Action: plans.Update,
+			Before: cty.ObjectVal(map[string]cty.Value{
+				"id":  cty.StringVal("i-02ae66f368e8518a9"),
+				"ami": cty.StringVal("ami-BEFORE"),
+			}),
+			After: cty.ObjectVal(map[string]cty.Value{
+				"id":  cty.StringVal("i-02ae66f368e8518a9"),
+				"ami": cty.StringVal("ami-AFTER"),
+			}),
+			Schema: &configschema.Block{
+				Attributes: map[string]*configschema.Attribute{
+					"id":  {Type: cty.String, Optional: true, Computed: true},
+					"ami": {Type: cty.String, Optional: true},
+				},
+			},
+			ExpectedAction: ActionUpdate,
+			ExpectedAttrs: map[string]Action{
+				"id":  ActionNoOp,
+				"ami": ActionUpdate,
+			},
+		},
+		"force-new update": {
+			Action: plans.DeleteThenCreate,
+			Before: cty.ObjectVal(map[string]cty.Value{
+				"id":  cty.StringVal("i-02ae66f368e8518a9"),
+				"ami": cty.StringVal("ami-BEFORE"),
+			}),
+			After: cty.ObjectVal(map[string]cty.Value{
+				"id":  cty.UnknownVal(cty.String),
+				"ami": cty.StringVal("ami-AFTER"),
+			}),
+			Schema: &configschema.Block{
+				Attributes: map[string]*configschema.Attribute{
+					"id":  {Type: cty.String, Optional: true, Computed: true},
+					"ami": {Type: cty.String, Optional: true},
+				},
+			},
+			ExpectedAction: ActionReplace,
+			ExpectedAttrs: map[string]Action{
+				"id":  ActionUpdate,
+				"ami": ActionUpdate,
+			},
+			ExpectedUnknown: map[string]bool{"id": true},
+		},
+		"nested object attribute": {
+			Action: plans.Update,
+			Before: cty.ObjectVal(map[string]cty.Value{
+				"tags": cty.ObjectVal(map[string]cty.Value{
+					"env": cty.StringVal("staging"),
+				}),
+			}),
+			After: cty.ObjectVal(map[string]cty.Value{
+				"tags": cty.ObjectVal(map[string]cty.Value{
+					"env": cty.StringVal("production"),
+				}),
+			}),
+			Schema: &configschema.Block{
+				Attributes: map[string]*configschema.Attribute{
+					"tags": {Type: cty.Object(map[string]cty.Type{"env": cty.String}), Optional: true},
+				},
+			},
+			ExpectedAction: ActionUpdate,
+			ExpectedAttrs:  map[string]Action{"tags": ActionUpdate},
+		},
+		"set and map attributes": {
+			Action: plans.Update,
+			Before: cty.ObjectVal(map[string]cty.Value{
+				"security_groups": cty.SetVal([]cty.Value{cty.StringVal("sg-1")}),
+				"labels":          cty.MapVal(map[string]cty.Value{"team": cty.StringVal("core")}),
+			}),
+			After: cty.ObjectVal(map[string]cty.Value{
+				"security_groups": cty.SetVal([]cty.Value{cty.StringVal("sg-1"), cty.StringVal("sg-2")}),
+				"labels":          cty.MapVal(map[string]cty.Value{"team": cty.StringVal("core")}),
+			}),
+			Schema: &configschema.Block{
+				Attributes: map[string]*configschema.Attribute{
+					"security_groups": {Type: cty.Set(cty.String), Optional: true},
+					"labels":          {Type: cty.Map(cty.String), Optional: true},
+				},
+			},
+			ExpectedAction: ActionUpdate,
+			ExpectedAttrs: map[string]Action{
+				"security_groups": ActionUpdate,
+				"labels":          ActionNoOp,
+			},
+		},
+		"attribute marked sensitive in schema": {
+			Action: plans.Update,
+			Before: cty.ObjectVal(map[string]cty.Value{
+				"password": cty.StringVal("top-secret"),
+			}),
+			After: cty.ObjectVal(map[string]cty.Value{
+				"password": cty.StringVal("even-more-secret"),
+			}),
+			Schema: &configschema.Block{
+				Attributes: map[string]*configschema.Attribute{
+					"password": {Type: cty.String, Optional: true, Sensitive: true},
+				},
+			},
+			ExpectedAction:             ActionUpdate,
+			ExpectedAttrs:              map[string]Action{"password": ActionUpdate},
+			ExpectedSensitive:          map[string]bool{"password": true},
+			ExpectedSensitivityChanged: map[string]bool{"password": false},
+		},
+		"value marked sensitive at runtime only on one side": {
+			Action: plans.Update,
+			Before: cty.ObjectVal(map[string]cty.Value{
+				"token": cty.StringVal("same-token"),
+			}),
+			After: cty.ObjectVal(map[string]cty.Value{
+				"token": cty.StringVal("same-token"),
+			}),
+			BeforeValMarks: []cty.PathValueMarks{
+				{
+					Path:  cty.Path{cty.GetAttrStep{Name: "token"}},
+					Marks: cty.NewValueMarks(marks.Sensitive),
+				},
+			},
+			Schema: &configschema.Block{
+				Attributes: map[string]*configschema.Attribute{
+					"token": {Type: cty.String, Optional: true},
+				},
+			},
+			ExpectedAction:             ActionUpdate,
+			ExpectedAttrs:              map[string]Action{"token": ActionUpdate},
+			ExpectedSensitive:          map[string]bool{"token": true},
+			ExpectedSensitivityChanged: map[string]bool{"token": true},
+		},
+		"nested list block with one element forcing replacement": {
+			Action: plans.DeleteThenCreate,
+			Before: cty.ObjectVal(map[string]cty.Value{
+				"network_interface": cty.ListVal([]cty.Value{
+					cty.ObjectVal(map[string]cty.Value{"subnet_id": cty.StringVal("subnet-0")}),
+					cty.ObjectVal(map[string]cty.Value{"subnet_id": cty.StringVal("subnet-1")}),
+				}),
+			}),
+			After: cty.ObjectVal(map[string]cty.Value{
+				"network_interface": cty.ListVal([]cty.Value{
+					cty.ObjectVal(map[string]cty.Value{"subnet_id": cty.StringVal("subnet-0")}),
+					cty.ObjectVal(map[string]cty.Value{"subnet_id": cty.StringVal("subnet-1-new")}),
+				}),
+			}),
+			Schema: &configschema.Block{
+				BlockTypes: map[string]*configschema.NestedBlock{
+					"network_interface": {
+						Nesting: configschema.NestingList,
+						Block: configschema.Block{
+							Attributes: map[string]*configschema.Attribute{
+								"subnet_id": {Type: cty.String, Optional: true},
+							},
+						},
+					},
+				},
+			},
+			RequiredReplace: cty.NewPathSet(cty.Path{
+				cty.GetAttrStep{Name: "network_interface"},
+				cty.IndexStep{Key: cty.NumberIntVal(1)},
+				cty.GetAttrStep{Name: "subnet_id"},
+			}),
+			ExpectedAction: ActionReplace,
+			ExpectedAttrs:  map[string]Action{"network_interface": ActionUpdate},
+		},
+		"nested single block update": {
+			Action: plans.Update,
+			Before: cty.ObjectVal(map[string]cty.Value{
+				"timeouts": cty.ObjectVal(map[string]cty.Value{
+					"create": cty.StringVal("10m"),
+				}),
+			}),
+			After: cty.ObjectVal(map[string]cty.Value{
+				"timeouts": cty.ObjectVal(map[string]cty.Value{
+					"create": cty.StringVal("20m"),
+				}),
+			}),
+			Schema: &configschema.Block{
+				BlockTypes: map[string]*configschema.NestedBlock{
+					"timeouts": {
+						Nesting: configschema.NestingSingle,
+						Block: configschema.Block{
+							Attributes: map[string]*configschema.Attribute{
+								"create": {Type: cty.String, Optional: true},
+							},
+						},
+					},
+				},
+			},
+			ExpectedAction: ActionUpdate,
+			ExpectedAttrs:  map[string]Action{"timeouts": ActionUpdate},
+		},
+		"nested group block with no change": {
+			Action: plans.Update,
+			Before: cty.ObjectVal(map[string]cty.Value{
+				"other": cty.StringVal("a"),
+				"lifecycle_rule": cty.ObjectVal(map[string]cty.Value{
+					"enabled": cty.True,
+				}),
+			}),
+			After: cty.ObjectVal(map[string]cty.Value{
+				"other": cty.StringVal("b"),
+				"lifecycle_rule": cty.ObjectVal(map[string]cty.Value{
+					"enabled": cty.True,
+				}),
+			}),
+			Schema: &configschema.Block{
+				Attributes: map[string]*configschema.Attribute{
+					"other": {Type: cty.String, Optional: true},
+				},
+				BlockTypes: map[string]*configschema.NestedBlock{
+					"lifecycle_rule": {
+						Nesting: configschema.NestingGroup,
+						Block: configschema.Block{
+							Attributes: map[string]*configschema.Attribute{
+								"enabled": {Type: cty.Bool, Optional: true},
+							},
+						},
+					},
+				},
+			},
+			ExpectedAction: ActionUpdate,
+			ExpectedAttrs: map[string]Action{
+				"other":          ActionUpdate,
+				"lifecycle_rule": ActionNoOp,
+			},
+		},
+		"nested set block with a sensitive element": {
+			Action: plans.Update,
+			Before: cty.ObjectVal(map[string]cty.Value{
+				"ingress": cty.SetVal([]cty.Value{
+					cty.ObjectVal(map[string]cty.Value{
+						"port": cty.NumberIntVal(22),
+						"cidr": cty.StringVal("10.0.0.0/8"),
+					}),
+				}),
+			}),
+			After: cty.ObjectVal(map[string]cty.Value{
+				"ingress": cty.SetVal([]cty.Value{
+					cty.ObjectVal(map[string]cty.Value{
+						"port": cty.NumberIntVal(22),
+						"cidr": cty.StringVal("10.0.0.0/8"),
+					}),
+					cty.ObjectVal(map[string]cty.Value{
+						"port": cty.NumberIntVal(443),
+						"cidr": cty.StringVal("0.0.0.0/0"),
+					}),
+				}),
+			}),
+			AfterValMarks: []cty.PathValueMarks{
+				{
+					Path: cty.Path{
+						cty.GetAttrStep{Name: "ingress"},
+						cty.IndexStep{Key: cty.ObjectVal(map[string]cty.Value{
+							"port": cty.NumberIntVal(443),
+							"cidr": cty.StringVal("0.0.0.0/0"),
+						})},
+						cty.GetAttrStep{Name: "cidr"},
+					},
+					Marks: cty.NewValueMarks(marks.Sensitive),
+				},
+			},
+			Schema: &configschema.Block{
+				BlockTypes: map[string]*configschema.NestedBlock{
+					"ingress": {
+						Nesting: configschema.NestingSet,
+						Block: configschema.Block{
+							Attributes: map[string]*configschema.Attribute{
+								"port": {Type: cty.Number, Optional: true},
+								"cidr": {Type: cty.String, Optional: true},
+							},
+						},
+					},
+				},
+			},
+			ExpectedAction: ActionUpdate,
+			ExpectedAttrs:  map[string]Action{"ingress": ActionUpdate},
+		},
+		"nested map block": {
+			Action: plans.Update,
+			Before: cty.ObjectVal(map[string]cty.Value{
+				"disk": cty.MapVal(map[string]cty.Value{
+					"root": cty.ObjectVal(map[string]cty.Value{"size": cty.NumberIntVal(20)}),
+				}),
+			}),
+			After: cty.ObjectVal(map[string]cty.Value{
+				"disk": cty.MapVal(map[string]cty.Value{
+					"root": cty.ObjectVal(map[string]cty.Value{"size": cty.NumberIntVal(40)}),
+				}),
+			}),
+			Schema: &configschema.Block{
+				BlockTypes: map[string]*configschema.NestedBlock{
+					"disk": {
+						Nesting: configschema.NestingMap,
+						Block: configschema.Block{
+							Attributes: map[string]*configschema.Attribute{
+								"size": {Type: cty.Number, Optional: true},
+							},
+						},
+					},
+				},
+			},
+			ExpectedAction: ActionUpdate,
+			ExpectedAttrs:  map[string]Action{"disk": ActionUpdate},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			before, err := plans.NewDynamicValue(tc.Before, tc.Before.Type())
+			if err != nil {
+				t.Fatal(err)
+			}
+			after, err := plans.NewDynamicValue(tc.After, tc.After.Type())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			change := &plans.ResourceInstanceChangeSrc{
+				Addr: addrs.Resource{
+					Mode: addrs.ManagedResourceMode,
+					Type: "test_instance",
+					Name: "example",
+				}.Instance(addrs.NoKey).Absolute(addrs.RootModuleInstance),
+				ProviderAddr: addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance),
+				ChangeSrc: plans.ChangeSrc{
+					Action:         tc.Action,
+					Before:         before,
+					After:          after,
+					BeforeValMarks: tc.BeforeValMarks,
+					AfterValMarks:  tc.AfterValMarks,
+				},
+				RequiredReplace: tc.RequiredReplace,
+			}
+
+			got, err := BuildResourceChange(change, tc.Schema)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got.Action != tc.ExpectedAction {
+				t.Errorf("wrong resource action: got %s, want %s", got.Action, tc.ExpectedAction)
+			}
+
+			for _, attr := range got.Change.Attributes {
+				if want, ok := tc.ExpectedAttrs[attr.Name]; ok && attr.Action != want {
+					t.Errorf("attribute %q: got action %s, want %s", attr.Name, attr.Action, want)
+				}
+				if want := tc.ExpectedUnknown[attr.Name]; want && !attr.Unknown {
+					t.Errorf("attribute %q: expected Unknown, got false", attr.Name)
+				}
+				if want, ok := tc.ExpectedSensitive[attr.Name]; ok && attr.Sensitive != want {
+					t.Errorf("attribute %q: got Sensitive %v, want %v", attr.Name, attr.Sensitive, want)
+				}
+				if want, ok := tc.ExpectedSensitivityChanged[attr.Name]; ok && attr.SensitivityChanged != want {
+					t.Errorf("attribute %q: got SensitivityChanged %v, want %v", attr.Name, attr.SensitivityChanged, want)
+				}
+
+				if attr.Name == "network_interface" {
+					if len(attr.Elements) != 2 {
+						t.Fatalf("network_interface: got %d elements, want 2", len(attr.Elements))
+					}
+					if attr.Elements[0].Action != ActionNoOp {
+						t.Errorf("network_interface[0]: got action %s, want %s", attr.Elements[0].Action, ActionNoOp)
+					}
+					changed := attr.Elements[1]
+					if changed.Action != ActionUpdate {
+						t.Errorf("network_interface[1]: got action %s, want %s", changed.Action, ActionUpdate)
+					}
+					if !changed.IsBlock {
+						t.Errorf("network_interface[1]: expected IsBlock")
+					}
+					var subnetID *Change
+					for _, a := range changed.Attributes {
+						if a.Name == "subnet_id" {
+							subnetID = a
+						}
+					}
+					if subnetID == nil {
+						t.Fatal("network_interface[1]: missing subnet_id attribute")
+					}
+					if !subnetID.ForcesReplacement {
+						t.Errorf("network_interface[1].subnet_id: expected ForcesReplacement")
+					}
+					if changed.ForcesReplacement {
+						t.Errorf("network_interface[1]: ForcesReplacement should be attributed to subnet_id, not the block")
+					}
+				}
+
+				if attr.Name == "timeouts" {
+					if !attr.IsBlock {
+						t.Errorf("timeouts: expected IsBlock")
+					}
+					if len(attr.Attributes) != 1 || attr.Attributes[0].Name != "create" || attr.Attributes[0].Action != ActionUpdate {
+						t.Errorf("timeouts: unexpected attributes %+v", attr.Attributes)
+					}
+				}
+
+				// ingress is a NestingSet block: the set's first element is
+				// retained unmarked and the second is a freshly-created
+				// element with a runtime-sensitive cidr. Matching it at all
+				// (rather than panicking or mismatching inside diffSet)
+				// is the regression this case guards.
+				if attr.Name == "ingress" {
+					if len(attr.Elements) != 2 {
+						t.Fatalf("ingress: got %d elements, want 2", len(attr.Elements))
+					}
+					if attr.Elements[0].Action != ActionNoOp {
+						t.Errorf("ingress[0]: got action %s, want %s", attr.Elements[0].Action, ActionNoOp)
+					}
+					created := attr.Elements[1]
+					if created.Action != ActionCreate {
+						t.Errorf("ingress[1]: got action %s, want %s", created.Action, ActionCreate)
+					}
+					var cidr *Change
+					for _, a := range created.Attributes {
+						if a.Name == "cidr" {
+							cidr = a
+						}
+					}
+					if cidr == nil {
+						t.Fatal("ingress[1]: missing cidr attribute")
+					}
+					if !cidr.Sensitive {
+						t.Errorf("ingress[1].cidr: expected Sensitive")
+					}
+				}
+
+				if attr.Name == "disk" {
+					if len(attr.Elements) != 1 {
+						t.Fatalf("disk: got %d elements, want 1", len(attr.Elements))
+					}
+					if attr.Elements[0].ElementKey != "root" {
+						t.Errorf("disk: got ElementKey %q, want %q", attr.Elements[0].ElementKey, "root")
+					}
+					if attr.Elements[0].Action != ActionUpdate {
+						t.Errorf("disk[root]: got action %s, want %s", attr.Elements[0].Action, ActionUpdate)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestChangeMarshalJSON verifies that the structured diff tree is actually
+// usable by the JSON consumers it's built for: every cty.Path step and
+// cty.Value must survive json.Marshal, and a sensitive value must never
+// appear in the encoded bytes even though Sensitive itself does.
+func TestChangeMarshalJSON(t *testing.T) {
+	schema := &configschema.Block{
+		Attributes: map[string]*configschema.Attribute{
+			"ami":      {Type: cty.String, Optional: true},
+			"password": {Type: cty.String, Optional: true, Sensitive: true},
+		},
+		BlockTypes: map[string]*configschema.NestedBlock{
+			"network_interface": {
+				Nesting: configschema.NestingList,
+				Block: configschema.Block{
+					Attributes: map[string]*configschema.Attribute{
+						"subnet_id": {Type: cty.String, Optional: true},
+					},
+				},
+			},
+			"ingress": {
+				Nesting: configschema.NestingSet,
+				Block: configschema.Block{
+					Attributes: map[string]*configschema.Attribute{
+						"port": {Type: cty.Number, Optional: true},
+						"cidr": {Type: cty.String, Optional: true},
+					},
+				},
+			},
+		},
+	}
+
+	newIngressRule := cty.ObjectVal(map[string]cty.Value{
+		"port": cty.NumberIntVal(443),
+		"cidr": cty.StringVal("0.0.0.0/0"),
+	})
+
+	before := cty.ObjectVal(map[string]cty.Value{
+		"ami":      cty.StringVal("ami-BEFORE"),
+		"password": cty.StringVal("top-secret"),
+		"network_interface": cty.ListVal([]cty.Value{
+			cty.ObjectVal(map[string]cty.Value{"subnet_id": cty.StringVal("subnet-0")}),
+		}),
+		"ingress": cty.SetVal([]cty.Value{
+			cty.ObjectVal(map[string]cty.Value{
+				"port": cty.NumberIntVal(22),
+				"cidr": cty.StringVal("10.0.0.0/8"),
+			}),
+		}),
+	})
+	after := cty.ObjectVal(map[string]cty.Value{
+		"ami":      cty.StringVal("ami-AFTER"),
+		"password": cty.StringVal("even-more-secret"),
+		"network_interface": cty.ListVal([]cty.Value{
+			cty.ObjectVal(map[string]cty.Value{"subnet_id": cty.StringVal("subnet-1")}),
+		}),
+		"ingress": cty.SetVal([]cty.Value{
+			cty.ObjectVal(map[string]cty.Value{
+				"port": cty.NumberIntVal(22),
+				"cidr": cty.StringVal("10.0.0.0/8"),
+			}),
+			newIngressRule,
+		}),
+	})
+
+	beforeDV, err := plans.NewDynamicValue(before, before.Type())
+	if err != nil {
+		t.Fatal(err)
+	}
+	afterDV, err := plans.NewDynamicValue(after, after.Type())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	change := &plans.ResourceInstanceChangeSrc{
+		Addr: addrs.Resource{
+			Mode: addrs.ManagedResourceMode,
+			Type: "test_instance",
+			Name: "example",
+		}.Instance(addrs.NoKey).Absolute(addrs.RootModuleInstance),
+		ProviderAddr: addrs.ProviderConfig{Type: "test"}.Absolute(addrs.RootModuleInstance),
+		ChangeSrc: plans.ChangeSrc{
+			Action: plans.Update,
+			Before: beforeDV,
+			After:  afterDV,
+		},
+		RequiredReplace: cty.NewPathSet(
+			cty.Path{
+				cty.GetAttrStep{Name: "network_interface"},
+				cty.IndexStep{Key: cty.NumberIntVal(0)},
+				cty.GetAttrStep{Name: "subnet_id"},
+			},
+			cty.Path{
+				cty.GetAttrStep{Name: "ingress"},
+				cty.IndexStep{Key: newIngressRule},
+				cty.GetAttrStep{Name: "port"},
+			},
+		),
+	}
+
+	diff, err := BuildResourceChange(change, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := json.Marshal(diff)
+	if err != nil {
+		t.Fatalf("json.Marshal: %s", err)
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		t.Fatalf("round-tripping the marshaled diff: %s", err)
+	}
+
+	rawChange, ok := decoded["Change"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("decoded output has no usable Change field: %s", raw)
+	}
+	attrs, ok := rawChange["attributes"].([]interface{})
+	if !ok {
+		t.Fatalf("decoded Change has no usable attributes field: %s", raw)
+	}
+
+	var sawAMI, sawNetworkInterface, sawForcedIngressPort bool
+	for _, a := range attrs {
+		attr, ok := a.(map[string]interface{})
+		if !ok {
+			t.Fatalf("attribute entry is not an object: %#v", a)
+		}
+		switch attr["name"] {
+		case "ami":
+			sawAMI = true
+			if path, ok := attr["path"].([]interface{}); !ok || len(path) != 1 || path[0] != "ami" {
+				t.Errorf("ami: unexpected path %#v", attr["path"])
+			}
+			if attr["before"] != "ami-BEFORE" || attr["after"] != "ami-AFTER" {
+				t.Errorf("ami: unexpected before/after %#v/%#v", attr["before"], attr["after"])
+			}
+		case "password":
+			if attr["sensitive"] != true {
+				t.Errorf("password: expected sensitive true, got %#v", attr["sensitive"])
+			}
+			if _, present := attr["before"]; present {
+				t.Errorf("password: before should not be present in JSON output, got %#v", attr["before"])
+			}
+			if _, present := attr["after"]; present {
+				t.Errorf("password: after should not be present in JSON output, got %#v", attr["after"])
+			}
+		case "network_interface":
+			sawNetworkInterface = true
+		case "ingress":
+			elements, ok := attr["elements"].([]interface{})
+			if !ok || len(elements) != 2 {
+				t.Fatalf("ingress: expected 2 decoded elements, got %#v", attr["elements"])
+			}
+			for _, e := range elements {
+				elem, ok := e.(map[string]interface{})
+				if !ok {
+					t.Fatalf("ingress element is not an object: %#v", e)
+				}
+				if elem["action"] != string(ActionCreate) {
+					continue
+				}
+				elemAttrs, ok := elem["attributes"].([]interface{})
+				if !ok {
+					t.Fatalf("ingress: created element has no usable attributes field: %#v", elem)
+				}
+				for _, ea := range elemAttrs {
+					portAttr, ok := ea.(map[string]interface{})
+					if !ok || portAttr["name"] != "port" {
+						continue
+					}
+					sawForcedIngressPort = true
+					if portAttr["forces_replacement"] != true {
+						t.Errorf("ingress created element's port: expected forces_replacement, got %#v", portAttr["forces_replacement"])
+					}
+					path, ok := portAttr["path"].([]interface{})
+					if !ok || len(path) != 3 {
+						t.Fatalf("ingress created element's port: unexpected path %#v", portAttr["path"])
+					}
+					if path[0] != "ingress" || path[2] != "port" {
+						t.Errorf("ingress created element's port: unexpected path %#v", path)
+					}
+					key, ok := path[1].(map[string]interface{})
+					if !ok {
+						t.Fatalf("ingress created element's port: expected path[1] to decode as an object key, got %#v", path[1])
+					}
+					if key["port"] != float64(443) || key["cidr"] != "0.0.0.0/0" {
+						t.Errorf("ingress created element's port: unexpected index key %#v", key)
+					}
+				}
+			}
+		}
+	}
+	if !sawAMI {
+		t.Errorf("decoded attributes missing ami: %s", raw)
+	}
+	if !sawNetworkInterface {
+		t.Errorf("decoded attributes missing network_interface: %s", raw)
+	}
+	if !sawForcedIngressPort {
+		t.Errorf("decoded attributes missing a forces-replacement port inside the ingress set: %s", raw)
+	}
+
+	if bytes.Contains(raw, []byte("top-secret")) || bytes.Contains(raw, []byte("even-more-secret")) {
+		t.Fatalf("sensitive value leaked into marshaled JSON: %s", raw)
+	}
+}
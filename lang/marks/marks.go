@@ -0,0 +1,19 @@
+// Package marks defines the cty value marks that Terraform's own language
+// and command implementations attach to cty.Values, independent of the
+// value's type. These are distinct from any marking a provider plugin might
+// do on its own terms; they are purely an implementation detail of this
+// codebase.
+package marks
+
+// valueMark is a unique type used for the marks used by Terraform to track
+// additional metadata on values that isn't part of their type.
+type valueMark string
+
+// Sensitive is used to mark values as sensitive, so that any value derived
+// from them (by a variable marked sensitive = true, or by a provider schema
+// attribute with Sensitive: true) is not shown directly in the UI.
+const Sensitive = valueMark("sensitive")
+
+func (m valueMark) GoString() string {
+	return "marks." + string(m)
+}